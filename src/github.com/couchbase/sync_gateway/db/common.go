@@ -0,0 +1,60 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"time"
+
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// LogEntry stores a single revision's entry in the channel log / cache.
+type LogEntry struct {
+	Sequence     uint64
+	DocID        string
+	RevID        string
+	TimeReceived time.Time
+	Channels     channels.ChannelMap
+	// TriggeredBy is non-zero when this entry is being emitted as part of a backfill
+	// triggered by a channel/role grant, and holds the sequence at which that grant
+	// occurred (see ChangesOptions.BackfillSince).
+	TriggeredBy uint64
+}
+
+// SequenceID identifies a position in a channel's change history. TriggeredBy and LowSeq
+// are non-zero only for entries surfaced via a backfill triggered by a channel/role grant.
+type SequenceID struct {
+	Seq         uint64
+	TriggeredBy uint64
+	LowSeq      uint64
+}
+
+// ChangesOptions controls the behavior of a changes feed request.
+type ChangesOptions struct {
+	Since      SequenceID
+	Terminator chan bool
+	Continuous bool
+	Wait       bool
+	// BackfillSince maps a channel name to the sequence at which the requesting user/role
+	// was granted access to it. When set for a channel, GetCachedChanges ignores Since for
+	// that channel and instead returns its entire cached history, with TriggeredBy set on
+	// every returned LogEntry so the caller can distinguish backfill from normal delivery.
+	BackfillSince map[string]uint64
+}
+
+// ChangeRev is a single "rev"->revID pair, as returned in a ChangeEntry's Changes list.
+type ChangeRev map[string]string
+
+// ChangeEntry is a single entry in a _changes feed response.
+type ChangeEntry struct {
+	Seq     SequenceID
+	ID      string
+	Changes []ChangeRev
+}
@@ -0,0 +1,36 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+// CacheInvalidator is implemented by both kvCache and PartitionedKvCache, letting callers (the
+// admin REST API, in particular) invalidate a channel's cache blocks without caring which cache
+// topology the database is configured with.
+type CacheInvalidator interface {
+	InvalidateChannels(channelNames []string, rebuild bool) error
+}
+
+// DatabaseContext is the per-database handle threaded through REST handlers as h.db. It wraps
+// whichever channel cache topology the database is configured with.
+type DatabaseContext struct {
+	Name  string
+	Cache CacheInvalidator
+}
+
+// NewDatabaseContext returns a DatabaseContext named name, backed by cache.
+func NewDatabaseContext(name string, cache CacheInvalidator) *DatabaseContext {
+	return &DatabaseContext{Name: name, Cache: cache}
+}
+
+// InvalidateChannelCaches purges (and, if rebuild is true, rebuilds) the cached blocks for
+// channelNames, via the database's configured channel cache. This is the method the admin
+// API's POST /{db}/_cache/invalidate endpoint calls.
+func (dbc *DatabaseContext) InvalidateChannelCaches(channelNames []string, rebuild bool) error {
+	return dbc.Cache.InvalidateChannels(channelNames, rebuild)
+}
@@ -0,0 +1,619 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// ByteCachePollingTime is the interval (in milliseconds) between polls of the cache bucket
+// for changes written by other gateway nodes.  Tests lower this to shrink wakeup latency.
+var ByteCachePollingTime = 1000
+
+// DefaultCacheBlockSize is the number of sequences stored per cache block, per channel.
+const DefaultCacheBlockSize = 10000
+
+// CacheOptions configures the distributed (bucket-backed) channel cache.
+type CacheOptions struct {
+	CacheBlockSize uint64        // Number of sequences per cache block. Defaults to DefaultCacheBlockSize.
+	MaxBlocks      int           // Maximum number of in-memory cache blocks across all channels. 0 means DefaultMaxBlocks.
+	MaxBytes       int64         // Maximum approximate in-memory size of cached blocks, in bytes. 0 means unbounded.
+	MaxAge         time.Duration // Maximum time a block may sit unused in memory before the pruner evicts it. 0 means DefaultMaxAge.
+	NotifierMode   NotifierMode  // How the cache learns about writes made by other gateway nodes. Defaults to PollNotifierMode.
+
+	// ReclaimPinnedAfterMaxAge lets the pruner evict a channel's pinned tail block once it's
+	// gone idle past MaxAge. Pinned blocks are otherwise exempt from every eviction path (see
+	// blockCache.evictOneLocked) for as long as they remain a channel's tail - this is an
+	// explicit opt-in for deployments with enough idle channels that the blanket exemption
+	// would otherwise grow the resident set unboundedly; it's off by default.
+	ReclaimPinnedAfterMaxAge bool
+
+	// NumShards is the number of independent kvCache shards NewPartitionedKvCache splits the
+	// channel space across. 0 means defaultShardCount. Unused by a plain (unsharded) kvCache.
+	NumShards uint32
+}
+
+// DefaultMaxBlocks and DefaultMaxAge bound the in-memory block cache when CacheOptions leaves
+// them unset.
+const (
+	DefaultMaxBlocks     = 10000
+	DefaultMaxAge        = 5 * time.Minute
+	blockPrunerInterval  = 30 * time.Second
+	approxBytesPerSeqNum = 8 // rough per-sequence overhead used to size blocks for MaxBytes accounting
+)
+
+// cacheBlock tracks which sequences within a fixed-size range have been written to a channel.
+type cacheBlock struct {
+	mutex     sync.RWMutex
+	index     uint64
+	sequences map[uint64]struct{}
+}
+
+func newCacheBlock(index uint64) *cacheBlock {
+	return &cacheBlock{
+		index:     index,
+		sequences: make(map[uint64]struct{}),
+	}
+}
+
+func (b *cacheBlock) addSequence(sequence uint64) {
+	b.mutex.Lock()
+	b.sequences[sequence] = struct{}{}
+	b.mutex.Unlock()
+}
+
+func (b *cacheBlock) hasSequence(sequence uint64) bool {
+	if b == nil {
+		return false
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, found := b.sequences[sequence]
+	return found
+}
+
+func (b *cacheBlock) sequenceList() []uint64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	list := make([]uint64, 0, len(b.sequences))
+	for sequence := range b.sequences {
+		list = append(list, sequence)
+	}
+	return list
+}
+
+// approxSizeBytes is a rough estimate of the block's in-memory footprint, used to enforce
+// CacheOptions.MaxBytes. It doesn't need to be exact, just proportional to sequence count.
+func (b *cacheBlock) approxSizeBytes() int64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return int64(len(b.sequences)) * approxBytesPerSeqNum
+}
+
+// cacheBlockDoc is the on-bucket representation of a cacheBlock, persisted under
+// blockKey(channel, index) so an evicted block can be reconstructed without replaying the
+// entire channel's sequence history.
+type cacheBlockDoc struct {
+	Sequences []uint64
+}
+
+// blockKey returns the bucket document key that stores the persisted membership for
+// (channelName, blockIndex).
+func blockKey(channelName string, blockIndex uint64) string {
+	return fmt.Sprintf("_cache:block:%s:%d", channelName, blockIndex)
+}
+
+func persistBlock(bucket base.Bucket, channelName string, block *cacheBlock) error {
+	raw, err := json.Marshal(cacheBlockDoc{Sequences: block.sequenceList()})
+	if err != nil {
+		return err
+	}
+	return bucket.SetRaw(blockKey(channelName, block.index), 0, raw)
+}
+
+// loadBlock reconstructs a cacheBlock from its persisted bucket doc, or returns nil if no
+// such block has ever been written.
+func loadBlock(bucket base.Bucket, channelName string, blockIndex uint64) *cacheBlock {
+	raw, err := bucket.GetRaw(blockKey(channelName, blockIndex))
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	var doc cacheBlockDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	block := newCacheBlock(blockIndex)
+	for _, sequence := range doc.Sequences {
+		block.sequences[sequence] = struct{}{}
+	}
+	return block
+}
+
+// cacheHelper manages the set of cache blocks for a single channel. Blocks themselves live in
+// the owning kvCache's shared, LRU-bounded blockCache; cacheHelper only tracks which block is
+// the current tail (the one actively being appended to, which must stay pinned in memory).
+type cacheHelper struct {
+	channelName string
+	bucket      base.Bucket
+	blockSize   uint64
+	owner       *kvCache
+	mutex       sync.RWMutex
+	tailIndex   uint64
+	hasTail     bool
+}
+
+func newCacheHelper(channelName string, bucket base.Bucket, blockSize uint64, owner *kvCache) *cacheHelper {
+	return &cacheHelper{
+		channelName: channelName,
+		bucket:      bucket,
+		blockSize:   blockSize,
+		owner:       owner,
+	}
+}
+
+func (h *cacheHelper) blockIndexForSequence(sequence uint64) uint64 {
+	return (sequence - 1) / h.blockSize
+}
+
+// readCacheBlockForSequence returns the cache block covering sequence, or nil if the channel
+// has no entries in that range. If the block isn't resident in memory, it's transparently
+// reloaded from the bucket (and repopulated into the LRU) before being returned.
+func (h *cacheHelper) readCacheBlockForSequence(sequence uint64) *cacheBlock {
+	return h.readBlock(h.blockIndexForSequence(sequence))
+}
+
+func (h *cacheHelper) readBlock(index uint64) *cacheBlock {
+	key := blockCacheKey{channelName: h.channelName, blockIndex: index}
+	if block, found := h.owner.blockCache.get(key); found {
+		return block
+	}
+
+	block := loadBlock(h.bucket, h.channelName, index)
+	if block == nil {
+		return nil
+	}
+	h.owner.blockCache.put(key, block, block.approxSizeBytes(), h.isTail(index))
+	return block
+}
+
+func (h *cacheHelper) isTail(index uint64) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.hasTail && h.tailIndex == index
+}
+
+// addSequence adds sequence to the appropriate cache block for this channel, persisting the
+// updated block to the bucket. The whole get-or-create -> mutate -> persist sequence runs under
+// h.mutex: two concurrent AddToCache calls for different docs in this channel must not each
+// load/create their own copy of the same block and then race to persist it, since whichever
+// persistBlock call lands last would silently overwrite the bucket's doc with only its own
+// sequence, dropping the other caller's sequence from the channel's index.
+func (h *cacheHelper) addSequence(sequence uint64) {
+	index := h.blockIndexForSequence(sequence)
+	key := blockCacheKey{channelName: h.channelName, blockIndex: index}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Only a block at or past the current tail becomes (or stays) the pinned tail; a late write
+	// into an older block leaves the existing tail pinned.
+	previousTail, hadTail := h.tailIndex, h.hasTail
+	isTail := !hadTail || index >= previousTail
+
+	block, found := h.owner.blockCache.get(key)
+	if !found {
+		block = loadBlock(h.bucket, h.channelName, index)
+		if block == nil {
+			block = newCacheBlock(index)
+		}
+	}
+	block.addSequence(sequence)
+	h.owner.blockCache.put(key, block, block.approxSizeBytes(), isTail)
+	if err := persistBlock(h.bucket, h.channelName, block); err != nil {
+		base.Warn("Error persisting cache block %s:%d: %v", h.channelName, index, err)
+	}
+
+	if isTail {
+		h.tailIndex = index
+		h.hasTail = true
+	}
+
+	if hadTail && index > previousTail {
+		h.owner.blockCache.setPinned(blockCacheKey{channelName: h.channelName, blockIndex: previousTail}, false)
+	}
+}
+
+// tailBlockIndex returns the highest block index written for this channel, and whether
+// any sequence has been written at all.
+func (h *cacheHelper) tailBlockIndex() (uint64, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.tailIndex, h.hasTail
+}
+
+// sequenceKey returns the bucket document key used to store the raw entry for a sequence.
+func sequenceKey(sequence uint64) string {
+	return fmt.Sprintf("_cache:seq:%d", sequence)
+}
+
+// readCacheEntry retrieves and decodes the LogEntry written for sequence, or nil if not found.
+func readCacheEntry(sequence uint64, bucket base.Bucket) *LogEntry {
+	raw, err := bucket.GetRaw(sequenceKey(sequence))
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	var entry LogEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+func writeCacheEntry(entry *LogEntry, bucket base.Bucket) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bucket.SetRaw(sequenceKey(entry.Sequence), 0, raw)
+}
+
+// kvCache is a single-shard, bucket-backed channel cache.  Entries are written once to a
+// shared sequence namespace (_cache:seq:N) and indexed per-channel into fixed-size blocks so
+// that GetCachedChanges can page through a channel's history without scanning every sequence.
+type kvCache struct {
+	storage       base.Bucket
+	options       CacheOptions
+	mutex         sync.RWMutex
+	channelCaches map[string]*cacheHelper
+	blockCache    *blockCache
+	onChange      func(changedChannels base.Set)
+	notifier      CacheNotifier
+	stopPruner    chan struct{}
+	maxSequence   uint64 // highest sequence seen by AddToCache; read/written via atomic
+}
+
+// Init prepares the cache for use.  initialSequence is the sequence the cache should be
+// considered caught up to on startup: it seeds the cache's highest known sequence, which bounds
+// rebuilds, grant sequences, and reads of channels this node hasn't written to since Init.
+func (k *kvCache) Init(initialSequence uint64) {
+	k.channelCaches = make(map[string]*cacheHelper)
+	if k.options.CacheBlockSize == 0 {
+		k.options.CacheBlockSize = DefaultCacheBlockSize
+	}
+	atomic.StoreUint64(&k.maxSequence, initialSequence)
+	k.blockCache = newBlockCache(k.options)
+	k.stopPruner = make(chan struct{})
+	go k.blockCache.runPruner(k.stopPruner)
+
+	k.notifier = newCacheNotifier(k.options.NotifierMode, k.storage, k.knownChannelNames)
+	if err := k.notifier.Start(k.notifyChannelsChanged, k.handleInvalidateMarker); err != nil {
+		base.Warn("Error starting cache notifier, falling back to polling: %v", err)
+		k.notifier = &PollNotifier{bucket: k.storage, knownChannels: k.knownChannelNames}
+		k.notifier.Start(k.notifyChannelsChanged, k.handleInvalidateMarker)
+	}
+}
+
+// knownChannelNames returns a snapshot of every channel this node currently has a cacheHelper
+// for, so a PollNotifier knows which invalidate markers to poll (see PollNotifier.checkInvalidateMarkers).
+func (k *kvCache) knownChannelNames() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	names := make([]string, 0, len(k.channelCaches))
+	for name := range k.channelCaches {
+		names = append(names, name)
+	}
+	return names
+}
+
+// handleInvalidateMarker drops the in-memory cacheHelper and any resident blocks for
+// channelName, in response to a peer node's InvalidateChannels call observed via the
+// notifier. Subsequent reads fall back to the bucket, which the peer has already rebuilt (or
+// left empty, if rebuild wasn't requested).
+func (k *kvCache) handleInvalidateMarker(channelName string) {
+	k.mutex.Lock()
+	delete(k.channelCaches, channelName)
+	k.mutex.Unlock()
+	k.blockCache.removeChannel(channelName)
+}
+
+func (k *kvCache) Stop() {
+	if k.stopPruner != nil {
+		close(k.stopPruner)
+	}
+	if k.notifier != nil {
+		k.notifier.Stop()
+	}
+}
+
+// notifyChannelsChanged is the per-channel notify path shared by local writes (AddToCache)
+// and remote writes observed by the configured CacheNotifier.
+func (k *kvCache) notifyChannelsChanged(changedChannels base.Set) {
+	if k.onChange != nil {
+		k.onChange(changedChannels)
+	}
+}
+
+// getCacheHelper returns the cacheHelper for channelName, creating an empty one if the
+// channel hasn't been written to yet.
+func (k *kvCache) getCacheHelper(channelName string) *cacheHelper {
+	k.mutex.RLock()
+	helper, found := k.channelCaches[channelName]
+	k.mutex.RUnlock()
+	if found {
+		return helper
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if helper, found = k.channelCaches[channelName]; found {
+		return helper
+	}
+	helper = newCacheHelper(channelName, k.storage, k.options.CacheBlockSize, k)
+	k.channelCaches[channelName] = helper
+	return helper
+}
+
+// AddToCache writes entry's sequence to the shared sequence namespace, then indexes it into
+// the cache block for every channel it's visible in (plus the "*" all-channels pseudo-channel).
+// It returns the names of every channel the entry was added to.
+func (k *kvCache) AddToCache(entry *LogEntry) []string {
+	if err := writeCacheEntry(entry, k.storage); err != nil {
+		base.Warn("Error writing cache entry for sequence %d: %v", entry.Sequence, err)
+		return nil
+	}
+
+	channelNames := make([]string, 0, len(entry.Channels)+1)
+	for channelName := range entry.Channels {
+		channelNames = append(channelNames, channelName)
+	}
+	channelNames = append(channelNames, channels.AllChannelWildcard)
+	return k.indexEntry(entry, channelNames)
+}
+
+// indexEntry updates the per-channel cache blocks for channelNames to include entry's
+// sequence, without re-writing entry's sequence doc. It's split out from AddToCache so that
+// PartitionedKvCache can write the sequence doc exactly once - on the single shared
+// _cache:seq:N namespace - and then fan the per-shard index update out to whichever shards
+// own entry's channels, instead of every shard redundantly (and racily) rewriting the doc
+// with only the slice of channels it happens to own.
+func (k *kvCache) indexEntry(entry *LogEntry, channelNames []string) []string {
+	bumpMaxSequence(&k.maxSequence, entry.Sequence)
+
+	addedTo := make([]string, 0, len(channelNames))
+	changedChannels := make(base.Set)
+	for _, channelName := range channelNames {
+		k.getCacheHelper(channelName).addSequence(entry.Sequence)
+		addedTo = append(addedTo, channelName)
+		changedChannels[channelName] = struct{}{}
+	}
+
+	k.notifyChannelsChanged(changedChannels)
+	return addedTo
+}
+
+// GetCachedChanges returns the sequence the results are valid from, and every entry cached
+// for channelName since options.Since - or, if options.BackfillSince names channelName, every
+// entry ever cached for it, each tagged with the triggering grant sequence.
+func (k *kvCache) GetCachedChanges(channelName string, options ChangesOptions) (uint64, []*LogEntry) {
+	helper := k.getCacheHelper(channelName)
+	tailIndex, found := helper.tailBlockIndex()
+	if !found {
+		// Nothing written to channelName through this node since Init (or since it was
+		// invalidated): read the persisted blocks up to the one covering the highest known sequence.
+		maxSequence := atomic.LoadUint64(&k.maxSequence)
+		if maxSequence == 0 {
+			return options.Since.Seq, nil
+		}
+		tailIndex = helper.blockIndexForSequence(maxSequence)
+	}
+
+	if triggeredBy, backfill := options.BackfillSince[channelName]; backfill {
+		entries := k.collectEntries(helper, 0, tailIndex, 0)
+		for _, entry := range entries {
+			entry.TriggeredBy = triggeredBy
+		}
+		sortLogEntries(entries)
+		return options.Since.Seq, entries
+	}
+
+	startIndex := helper.blockIndexForSequence(options.Since.Seq + 1)
+	entries := k.collectEntries(helper, startIndex, tailIndex, options.Since.Seq)
+	sortLogEntries(entries)
+	return options.Since.Seq, entries
+}
+
+// collectEntries reads every cache block in [startIndex, tailIndex] for helper's channel and
+// returns the decoded entries for sequences greater than sinceSeq.
+func (k *kvCache) collectEntries(helper *cacheHelper, startIndex, tailIndex uint64, sinceSeq uint64) []*LogEntry {
+	entries := make([]*LogEntry, 0)
+	for index := startIndex; index <= tailIndex; index++ {
+		block := helper.readBlock(index)
+		if block == nil {
+			continue
+		}
+		for _, sequence := range block.sequenceList() {
+			if sequence <= sinceSeq {
+				continue
+			}
+			if entry := readCacheEntry(sequence, k.storage); entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// GetChanges is equivalent to GetCachedChanges, but matches the signature used by callers
+// that don't need the valid-from sequence (e.g. MultiChangesFeed).
+func (k *kvCache) GetChanges(channelName string, options ChangesOptions) ([]*LogEntry, error) {
+	_, entries := k.GetCachedChanges(channelName, options)
+	return entries, nil
+}
+
+// bumpMaxSequence atomically raises *maxSequence to sequence, if it isn't already higher.
+func bumpMaxSequence(maxSequence *uint64, sequence uint64) {
+	for {
+		current := atomic.LoadUint64(maxSequence)
+		if sequence <= current {
+			return
+		}
+		if atomic.CompareAndSwapUint64(maxSequence, current, sequence) {
+			return
+		}
+	}
+}
+
+func sortLogEntries(entries []*LogEntry) {
+	for i := 1; i < len(entries); i++ {
+		for j := i; j > 0 && entries[j].Sequence < entries[j-1].Sequence; j-- {
+			entries[j], entries[j-1] = entries[j-1], entries[j]
+		}
+	}
+}
+
+// --- Partitioning -----------------------------------------------------------------------
+
+// defaultShardCount is the number of shards a PartitionedKvCache splits into when CacheOptions
+// doesn't specify one.
+const defaultShardCount = 16
+
+// shardForChannel hash-partitions a channel name into one of numShards shards using FNV-1a,
+// so the same channel always routes to the same underlying kvCache.
+func shardForChannel(channelName string, numShards uint32) uint32 {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(channelName))
+	return hasher.Sum32() % numShards
+}
+
+// PartitionedKvCache hash-partitions channels across a fixed number of independent kvCache
+// shards, so that contention on AddToCache/GetCachedChanges for one channel doesn't block
+// unrelated channels. Sequence entries are still written to the single shared _cache:seq:N
+// namespace, so code that reads sequences directly from the bucket is unaffected by sharding.
+type PartitionedKvCache struct {
+	storage   base.Bucket
+	options   CacheOptions
+	numShards uint32
+	shards    []*kvCache
+}
+
+// NewPartitionedKvCache creates a PartitionedKvCache with options.NumShards independent kvCache
+// shards, all backed by the same bucket. If options.NumShards is 0, defaultShardCount is used.
+func NewPartitionedKvCache(bucket base.Bucket, options CacheOptions) *PartitionedKvCache {
+	numShards := options.NumShards
+	if numShards == 0 {
+		numShards = defaultShardCount
+	}
+	p := &PartitionedKvCache{
+		storage:   bucket,
+		options:   options,
+		numShards: numShards,
+		shards:    make([]*kvCache, numShards),
+	}
+	for i := range p.shards {
+		shard := &kvCache{storage: bucket, options: options}
+		shard.Init(0)
+		p.shards[i] = shard
+	}
+	return p
+}
+
+func (p *PartitionedKvCache) shardForChannel(channelName string) *kvCache {
+	return p.shards[shardForChannel(channelName, p.numShards)]
+}
+
+func (p *PartitionedKvCache) Stop() {
+	for _, shard := range p.shards {
+		shard.Stop()
+	}
+}
+
+// getCacheHelper routes to the cacheHelper owned by the shard responsible for channelName.
+func (p *PartitionedKvCache) getCacheHelper(channelName string) *cacheHelper {
+	return p.shardForChannel(channelName).getCacheHelper(channelName)
+}
+
+// SetOnChange registers fn to be invoked whenever a shard observes new entries for one or
+// more of its channels - the partitioned equivalent of kvCache.onChange. Each shard notifies
+// independently with only its own changed channels, so a write isolated to one shard never
+// invokes fn with another shard's channels.
+func (p *PartitionedKvCache) SetOnChange(fn func(base.Set)) {
+	for _, shard := range p.shards {
+		shard.onChange = fn
+	}
+}
+
+// AddToCache writes entry's sequence doc exactly once, to the single shared _cache:seq:N
+// namespace, then groups entry's channels by owning shard and dispatches each group's index
+// update to its shard in parallel, since independent shards share no locks.
+func (p *PartitionedKvCache) AddToCache(entry *LogEntry) []string {
+	if err := writeCacheEntry(entry, p.storage); err != nil {
+		base.Warn("Error writing cache entry for sequence %d: %v", entry.Sequence, err)
+		return nil
+	}
+
+	byShard := make(map[uint32][]string)
+	for channelName := range entry.Channels {
+		shardIndex := shardForChannel(channelName, p.numShards)
+		byShard[shardIndex] = append(byShard[shardIndex], channelName)
+	}
+	wildcardShard := shardForChannel(channels.AllChannelWildcard, p.numShards)
+	byShard[wildcardShard] = append(byShard[wildcardShard], channels.AllChannelWildcard)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	addedTo := make([]string, 0, len(entry.Channels)+1)
+	for shardIndex, channelNames := range byShard {
+		wg.Add(1)
+		go func(shardIndex uint32, channelNames []string) {
+			defer wg.Done()
+			added := p.shards[shardIndex].indexEntry(entry, channelNames)
+			mutex.Lock()
+			addedTo = append(addedTo, added...)
+			mutex.Unlock()
+		}(shardIndex, channelNames)
+	}
+	wg.Wait()
+	return addedTo
+}
+
+// InvalidateChannels groups channelNames by owning shard and invalidates each group on its
+// shard, so callers (e.g. the admin REST API) don't need to know the database's cache is
+// partitioned.
+func (p *PartitionedKvCache) InvalidateChannels(channelNames []string, rebuild bool) error {
+	byShard := make(map[uint32][]string)
+	for _, channelName := range channelNames {
+		shardIndex := shardForChannel(channelName, p.numShards)
+		byShard[shardIndex] = append(byShard[shardIndex], channelName)
+	}
+	for shardIndex, names := range byShard {
+		if err := p.shards[shardIndex].InvalidateChannels(names, rebuild); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCachedChanges routes to the shard that owns channelName.
+func (p *PartitionedKvCache) GetCachedChanges(channelName string, options ChangesOptions) (uint64, []*LogEntry) {
+	return p.shardForChannel(channelName).GetCachedChanges(channelName, options)
+}
+
+// GetChanges routes to the shard that owns channelName.
+func (p *PartitionedKvCache) GetChanges(channelName string, options ChangesOptions) ([]*LogEntry, error) {
+	return p.shardForChannel(channelName).GetChanges(channelName, options)
+}
@@ -0,0 +1,100 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// grantSequenceKey returns the bucket document key used to remember the sequence at which
+// principalName (a user or role name) was granted access to channelName, so a restarted
+// gateway can resume any in-flight backfill without needing the grant to be re-applied.
+func grantSequenceKey(principalName string, channelName string) string {
+	return fmt.Sprintf("_sync:grant:%s:%s", principalName, channelName)
+}
+
+type grantSequenceDoc struct {
+	TriggeredBy uint64
+}
+
+// PersistGrantSequence records that principalName was granted access to channelName at
+// triggeredBy, alongside the user/role document. Callers pass this value back in
+// ChangesOptions.BackfillSince to resume the backfill after a restart.
+func PersistGrantSequence(bucket base.Bucket, principalName string, channelName string, triggeredBy uint64) error {
+	raw, err := json.Marshal(grantSequenceDoc{TriggeredBy: triggeredBy})
+	if err != nil {
+		return err
+	}
+	return bucket.SetRaw(grantSequenceKey(principalName, channelName), 0, raw)
+}
+
+// ReadGrantSequence returns the sequence at which principalName was granted channelName, and
+// whether a grant has been recorded at all.
+func ReadGrantSequence(bucket base.Bucket, principalName string, channelName string) (uint64, bool) {
+	raw, err := bucket.GetRaw(grantSequenceKey(principalName, channelName))
+	if err != nil || len(raw) == 0 {
+		return 0, false
+	}
+	var doc grantSequenceDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return 0, false
+	}
+	return doc.TriggeredBy, true
+}
+
+// GrantChannel persists that principalName was granted access to channelName at the cache's
+// current sequence, then returns every entry ever cached for channelName, each tagged with that
+// grant sequence as its TriggeredBy -
+// this is the production entry point access-grant handling should call instead of PersistGrantSequence
+// directly, since it keeps the persisted marker and the returned backfill in step.
+func (k *kvCache) GrantChannel(channelName string, principalName string) (uint64, []*LogEntry, error) {
+	triggeredBy := atomic.LoadUint64(&k.maxSequence)
+	if err := PersistGrantSequence(k.storage, principalName, channelName, triggeredBy); err != nil {
+		return 0, nil, err
+	}
+	options := ChangesOptions{BackfillSince: map[string]uint64{channelName: triggeredBy}}
+	_, entries := k.GetCachedChanges(channelName, options)
+	return triggeredBy, entries, nil
+}
+
+// GrantChannel is the PartitionedKvCache equivalent of kvCache.GrantChannel. triggeredBy is
+// sourced from the shard owning the "*" all-channels wildcard rather than the shard owning
+// channelName: every entry is indexed into the "*" cacheHelper on that one shard (see AddToCache), so
+// that shard's maxSequence is the database's true current sequence, not just the local
+// high-water mark of whichever shard happens to own channelName.
+func (p *PartitionedKvCache) GrantChannel(channelName string, principalName string) (uint64, []*LogEntry, error) {
+	wildcardShard := p.shardForChannel(channels.AllChannelWildcard)
+	triggeredBy := atomic.LoadUint64(&wildcardShard.maxSequence)
+	if err := PersistGrantSequence(p.storage, principalName, channelName, triggeredBy); err != nil {
+		return 0, nil, err
+	}
+	options := ChangesOptions{BackfillSince: map[string]uint64{channelName: triggeredBy}}
+	_, entries := p.GetCachedChanges(channelName, options)
+	return triggeredBy, entries, nil
+}
+
+// ResumeGrantedBackfills reads back every grant previously persisted by GrantChannel for
+// principalName across channelNames, reconstructing the BackfillSince map a restarted gateway
+// needs to pass into GetCachedChanges/GetChanges to resume in-flight backfills that were
+// interrupted by the restart. Channels with no recorded grant are omitted from the result.
+func ResumeGrantedBackfills(bucket base.Bucket, principalName string, channelNames []string) map[string]uint64 {
+	backfillSince := make(map[string]uint64, len(channelNames))
+	for _, channelName := range channelNames {
+		if triggeredBy, found := ReadGrantSequence(bucket, principalName, channelName); found {
+			backfillSince[channelName] = triggeredBy
+		}
+	}
+	return backfillSince
+}
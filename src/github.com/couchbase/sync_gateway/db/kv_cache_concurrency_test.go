@@ -0,0 +1,46 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/couchbaselabs/go.assert"
+)
+
+// TestConcurrentAddToCacheSameChannel writes many sequences to a single channel from
+// concurrent goroutines - the "thousands of active channels" workload chunk0-1 targets, applied
+// to one hot channel - and verifies every sequence survives. Before addSequence serialized its
+// get-or-create -> mutate -> persist critical section per channel, two goroutines racing on the
+// same cache block could each load/create their own copy and persist it, with the last writer
+// silently dropping the other's sequence from the bucket.
+func TestConcurrentAddToCacheSameChannel(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{})
+
+	const numSequences = 500
+	const numWriters = 10
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numSequences/numWriters; i++ {
+				seq := uint64(w+i*numWriters) + 1
+				cache.AddToCache(channelEntry(seq, fmt.Sprintf("doc%d", seq), "1-a", []string{"ABC"}))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	_, results := cache.GetCachedChanges("ABC", ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.Equals(t, len(results), numSequences)
+}
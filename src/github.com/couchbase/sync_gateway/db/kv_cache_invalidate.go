@@ -0,0 +1,122 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
+)
+
+// invalidateKey returns the bucket document key used to broadcast that channelName's cache
+// blocks have been invalidated, so peer nodes' CacheNotifiers can drop their own in-memory
+// copies (see kvCache.handleInvalidateMarker).
+func invalidateKey(channelName string) string {
+	return fmt.Sprintf("_cache:invalidate:%s", channelName)
+}
+
+// invalidateMarker is the document written to invalidateKey. Version increases on every
+// invalidation so a PollNotifier - which has no tap feed to tell it a key changed - can detect
+// a new invalidation by comparing against the last Version it saw for that channel, rather than
+// only being able to tell that a marker exists at all.
+type invalidateMarker struct {
+	Version int64
+}
+
+func writeInvalidateMarker(bucket base.Bucket, channelName string) error {
+	raw, err := json.Marshal(invalidateMarker{Version: time.Now().UnixNano()})
+	if err != nil {
+		return err
+	}
+	return bucket.SetRaw(invalidateKey(channelName), 0, raw)
+}
+
+// readInvalidateMarker returns the Version of the most recent invalidation recorded for
+// channelName, and whether one has ever been recorded.
+func readInvalidateMarker(bucket base.Bucket, channelName string) (int64, bool) {
+	raw, err := bucket.GetRaw(invalidateKey(channelName))
+	if err != nil || len(raw) == 0 {
+		return 0, false
+	}
+	var marker invalidateMarker
+	if err := json.Unmarshal(raw, &marker); err != nil {
+		return 0, false
+	}
+	return marker.Version, true
+}
+
+// InvalidateChannels deletes the persisted and in-memory cache blocks for channelNames,
+// broadcasts the invalidation to peer nodes, and - if rebuild is true - replays every cached
+// sequence entry to reconstruct the blocks in order. Persisted blocks are deleted from block 0
+// up to the later of this node's tail block for the channel and the block covering the highest
+// sequence this node has seen; blocks past that point are not deleted.
+func (k *kvCache) InvalidateChannels(channelNames []string, rebuild bool) error {
+	for _, channelName := range channelNames {
+		if err := k.invalidateChannel(channelName, rebuild); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *kvCache) invalidateChannel(channelName string, rebuild bool) error {
+	helper := k.getCacheHelper(channelName)
+	tailIndex, found := helper.tailBlockIndex()
+	if maxSequence := atomic.LoadUint64(&k.maxSequence); maxSequence > 0 {
+		if maxIndex := helper.blockIndexForSequence(maxSequence); !found || maxIndex > tailIndex {
+			tailIndex, found = maxIndex, true
+		}
+	}
+	if found {
+		for index := uint64(0); index <= tailIndex; index++ {
+			if err := k.storage.Delete(blockKey(channelName, index)); err != nil {
+				base.Warn("Error deleting cache block %s:%d: %v", channelName, index, err)
+			}
+		}
+	}
+
+	k.mutex.Lock()
+	delete(k.channelCaches, channelName)
+	k.mutex.Unlock()
+	k.blockCache.removeChannel(channelName)
+
+	if err := writeInvalidateMarker(k.storage, channelName); err != nil {
+		return err
+	}
+
+	if rebuild {
+		k.rebuildChannel(channelName)
+	}
+	return nil
+}
+
+// rebuildChannel replays every sequence entry in the shared _cache:seq:N namespace, up to the
+// highest sequence this node has seen, re-indexing any that are visible to channelName (or
+// the "*" all-channels pseudo-channel) into fresh cache blocks.
+func (k *kvCache) rebuildChannel(channelName string) {
+	helper := k.getCacheHelper(channelName)
+	maxSequence := atomic.LoadUint64(&k.maxSequence)
+	for sequence := uint64(1); sequence <= maxSequence; sequence++ {
+		entry := readCacheEntry(sequence, k.storage)
+		if entry == nil {
+			continue
+		}
+		if channelName != channels.AllChannelWildcard {
+			if _, visible := entry.Channels[channelName]; !visible {
+				continue
+			}
+		}
+		helper.addSequence(sequence)
+	}
+}
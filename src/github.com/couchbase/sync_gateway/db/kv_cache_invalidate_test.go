@@ -0,0 +1,52 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbaselabs/go.assert"
+)
+
+func TestInvalidateChannelsWithRebuild(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{})
+
+	const numEntries = 3000
+	for seq := uint64(1); seq <= numEntries; seq++ {
+		cache.AddToCache(channelEntry(seq, fmt.Sprintf("doc%d", seq), "1-a", []string{"ABC"}))
+	}
+
+	options := ChangesOptions{Since: SequenceID{Seq: 0}}
+	_, before := cache.GetCachedChanges("ABC", options)
+	assert.Equals(t, len(before), numEntries)
+
+	err := cache.InvalidateChannels([]string{"ABC"}, true)
+	assert.True(t, err == nil)
+
+	_, after := cache.GetCachedChanges("ABC", options)
+	assert.Equals(t, len(after), len(before))
+	for i := range before {
+		assert.Equals(t, after[i].Sequence, before[i].Sequence)
+		assert.Equals(t, after[i].DocID, before[i].DocID)
+	}
+}
+
+func TestInvalidateChannelsWithoutRebuildIsEmpty(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{})
+	cache.AddToCache(channelEntry(1, "foo1", "1-a", []string{"ABC"}))
+
+	err := cache.InvalidateChannels([]string{"ABC"}, false)
+	assert.True(t, err == nil)
+
+	options := ChangesOptions{Since: SequenceID{Seq: 0}}
+	_, results := cache.GetCachedChanges("ABC", options)
+	assert.Equals(t, len(results), 0)
+}
@@ -0,0 +1,222 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockCacheKey identifies a single cache block across all channels sharing a kvCache.
+type blockCacheKey struct {
+	channelName string
+	blockIndex  uint64
+}
+
+// blockCacheEntry is the value stored in the LRU list; pinned entries (the tail block of a
+// channel that's actively being written) are skipped by both LRU eviction and the pruner.
+type blockCacheEntry struct {
+	key        blockCacheKey
+	block      *cacheBlock
+	sizeBytes  int64
+	lastAccess time.Time
+	pinned     bool
+}
+
+// blockCache is an LRU-with-age-and-size-bounds cache of cacheBlocks, shared by every
+// cacheHelper belonging to one kvCache. It exists so a gateway with wide channel fan-out
+// doesn't grow its resident set unboundedly: blocks evicted here are still retrievable from
+// the bucket via loadBlock, just more slowly.
+type blockCache struct {
+	options CacheOptions
+
+	mutex        sync.Mutex
+	ll           *list.List // front = most recently used
+	items        map[blockCacheKey]*list.Element
+	currentBytes int64
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func newBlockCache(options CacheOptions) *blockCache {
+	if options.MaxBlocks == 0 {
+		options.MaxBlocks = DefaultMaxBlocks
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = DefaultMaxAge
+	}
+	return &blockCache{
+		options: options,
+		ll:      list.New(),
+		items:   make(map[blockCacheKey]*list.Element),
+	}
+}
+
+// get returns the block for key, moving it to the front of the LRU, or (nil, false) on a
+// miss. Hit/miss are tracked for the Prometheus-style counters exposed by kvCache.
+func (c *blockCache) get(key blockCacheKey) (*cacheBlock, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, found := c.items[key]
+	if !found {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	entry := elem.Value.(*blockCacheEntry)
+	entry.lastAccess = blockCacheNow()
+	c.ll.MoveToFront(elem)
+	return entry.block, true
+}
+
+// put inserts or updates the cached block for key, then evicts LRU entries as needed to stay
+// within MaxBlocks/MaxBytes. Pinned entries are never evicted by this pass.
+func (c *blockCache) put(key blockCacheKey, block *cacheBlock, sizeBytes int64, pinned bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.items[key]; found {
+		entry := elem.Value.(*blockCacheEntry)
+		c.currentBytes += sizeBytes - entry.sizeBytes
+		entry.block = block
+		entry.sizeBytes = sizeBytes
+		entry.lastAccess = blockCacheNow()
+		entry.pinned = pinned
+		c.ll.MoveToFront(elem)
+	} else {
+		entry := &blockCacheEntry{key: key, block: block, sizeBytes: sizeBytes, lastAccess: blockCacheNow(), pinned: pinned}
+		c.items[key] = c.ll.PushFront(entry)
+		c.currentBytes += sizeBytes
+	}
+	c.evictLocked()
+}
+
+// setPinned updates whether the block at key is exempt from eviction, without otherwise
+// touching its LRU position. Used when a channel's tail block changes: the new tail is
+// pinned, the previous one becomes eligible for normal LRU eviction again.
+func (c *blockCache) setPinned(key blockCacheKey, pinned bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, found := c.items[key]; found {
+		elem.Value.(*blockCacheEntry).pinned = pinned
+	}
+}
+
+// evictLocked removes least-recently-used, unpinned entries until the cache is back within
+// its configured bounds. Must be called with c.mutex held.
+func (c *blockCache) evictLocked() {
+	for c.overBoundsLocked() {
+		if !c.evictOneLocked() {
+			return // nothing left that's safe to evict
+		}
+	}
+}
+
+func (c *blockCache) overBoundsLocked() bool {
+	if c.options.MaxBlocks > 0 && len(c.items) > c.options.MaxBlocks {
+		return true
+	}
+	if c.options.MaxBytes > 0 && c.currentBytes > c.options.MaxBytes {
+		return true
+	}
+	return false
+}
+
+// evictOneLocked evicts the least-recently-used unpinned entry, returning false if every
+// entry is pinned (in which case bounds may legitimately stay exceeded).
+func (c *blockCache) evictOneLocked() bool {
+	for elem := c.ll.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*blockCacheEntry)
+		if entry.pinned {
+			continue
+		}
+		c.ll.Remove(elem)
+		delete(c.items, entry.key)
+		c.currentBytes -= entry.sizeBytes
+		atomic.AddInt64(&c.evictions, 1)
+		return true
+	}
+	return false
+}
+
+// pruneExpired evicts unpinned entries older than MaxAge, regardless of size/count bounds. A
+// channel's pinned tail block is exempt from this, same as it's exempt from evictOneLocked,
+// unless CacheOptions.ReclaimPinnedAfterMaxAge opts into reclaiming idle pinned tails too - see
+// that field's doc comment for why a deployment might want that.
+func (c *blockCache) pruneExpired() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cutoff := blockCacheNow().Add(-c.options.MaxAge)
+	for elem := c.ll.Back(); elem != nil; {
+		prev := elem.Prev()
+		entry := elem.Value.(*blockCacheEntry)
+		if (!entry.pinned || c.options.ReclaimPinnedAfterMaxAge) && entry.lastAccess.Before(cutoff) {
+			c.ll.Remove(elem)
+			delete(c.items, entry.key)
+			c.currentBytes -= entry.sizeBytes
+			atomic.AddInt64(&c.evictions, 1)
+		}
+		elem = prev
+	}
+}
+
+// runPruner periodically evicts aged-out blocks until stop is closed.
+func (c *blockCache) runPruner(stop chan struct{}) {
+	ticker := time.NewTicker(blockPrunerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.pruneExpired()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// removeChannel evicts every resident block belonging to channelName, regardless of pin
+// state. Used when a channel's cache has been explicitly invalidated (locally or by a peer),
+// since those blocks are about to be deleted from (or rewritten in) the bucket.
+func (c *blockCache) removeChannel(channelName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for elem := c.ll.Back(); elem != nil; {
+		prev := elem.Prev()
+		entry := elem.Value.(*blockCacheEntry)
+		if entry.key.channelName == channelName {
+			c.ll.Remove(elem)
+			delete(c.items, entry.key)
+			c.currentBytes -= entry.sizeBytes
+		}
+		elem = prev
+	}
+}
+
+func (c *blockCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.items)
+}
+
+// HitCount, MissCount and EvictionCount expose Prometheus-style counters for the block cache
+// backing this kvCache.
+func (k *kvCache) HitCount() int64      { return atomic.LoadInt64(&k.blockCache.hits) }
+func (k *kvCache) MissCount() int64     { return atomic.LoadInt64(&k.blockCache.misses) }
+func (k *kvCache) EvictionCount() int64 { return atomic.LoadInt64(&k.blockCache.evictions) }
+
+// blockCacheNow is a seam over time.Now so tests can't accidentally rely on wall-clock
+// granularity; kept as a var for parity with the rest of the package's testability patterns.
+var blockCacheNow = time.Now
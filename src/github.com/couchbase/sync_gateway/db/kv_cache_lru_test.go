@@ -0,0 +1,124 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbaselabs/go.assert"
+)
+
+func testKvCacheWithOptions(options CacheOptions) (*kvCache, base.Bucket) {
+	cacheBucket, err := ConnectToBucket(base.BucketSpec{
+		Server:     "walrus:",
+		BucketName: "distributed_cache_lru_test"})
+	if err != nil {
+		panic("Couldn't connect to cache bucket")
+	}
+	cache := &kvCache{storage: cacheBucket, options: options}
+	cache.Init(uint64(0))
+	return cache, cacheBucket
+}
+
+// TestBlockCacheEviction demonstrates that a resident, pinned tail block per active channel is
+// exempt from count/byte-bounded LRU eviction - MaxBlocks only bounds the *unpinned* working
+// set, not the number of live channels - and that pinned tails stay exempt from MaxAge pruning
+// too, by default: pinned means pinned, per the backlog's "Pinned blocks ... must be exempt
+// from eviction." Deployments that need a hard bound on idle pinned tails can opt into
+// ReclaimPinnedAfterMaxAge (see TestBlockCacheReclaimPinnedAfterMaxAgeOptIn).
+func TestBlockCacheEviction(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{MaxBlocks: 5, MaxAge: 10 * time.Millisecond})
+
+	// Write two sequences to each of more channels than MaxBlocks. Each channel's second write
+	// lands in a new block and becomes that channel's pinned tail, so every channel ends up
+	// with one permanently-pinned resident block - LRU eviction alone can't bring the cache
+	// back within MaxBlocks no matter how many more unrelated channels are written.
+	for i := 0; i < 10; i++ {
+		channelName := fmt.Sprintf("chan%d", i)
+		cache.AddToCache(channelEntry(uint64(i*20000+1), "doc", "1-a", []string{channelName}))
+		cache.AddToCache(channelEntry(uint64(i*20000+10001), "doc2", "1-a", []string{channelName}))
+	}
+	assert.True(t, cache.blockCache.len() > 5)
+
+	// Advance the clock well past MaxAge without any further writes, so every pinned tail is
+	// now idle. Without ReclaimPinnedAfterMaxAge, pruneExpired must still leave them alone.
+	now := blockCacheNow()
+	defer func() { blockCacheNow = time.Now }()
+	blockCacheNow = func() time.Time { return now.Add(time.Hour) }
+
+	cache.blockCache.pruneExpired()
+
+	assert.True(t, cache.blockCache.len() > 5)
+}
+
+// TestBlockCacheReclaimPinnedAfterMaxAgeOptIn verifies the ReclaimPinnedAfterMaxAge knob: with
+// it set, pruneExpired reclaims idle pinned tails too, bringing the cache back within MaxBlocks
+// for deployments that need that harder bound instead of the default blanket pin exemption.
+func TestBlockCacheReclaimPinnedAfterMaxAgeOptIn(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{MaxBlocks: 5, MaxAge: 10 * time.Millisecond, ReclaimPinnedAfterMaxAge: true})
+
+	for i := 0; i < 10; i++ {
+		channelName := fmt.Sprintf("chan%d", i)
+		cache.AddToCache(channelEntry(uint64(i*20000+1), "doc", "1-a", []string{channelName}))
+		cache.AddToCache(channelEntry(uint64(i*20000+10001), "doc2", "1-a", []string{channelName}))
+	}
+	assert.True(t, cache.blockCache.len() > 5)
+
+	now := blockCacheNow()
+	defer func() { blockCacheNow = time.Now }()
+	blockCacheNow = func() time.Time { return now.Add(time.Hour) }
+
+	cache.blockCache.pruneExpired()
+
+	assert.True(t, cache.blockCache.len() <= 5)
+	assert.True(t, cache.EvictionCount() > 0)
+}
+
+func TestBlockCacheRoundTripAfterEviction(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{MaxBlocks: 2})
+
+	cache.AddToCache(channelEntry(1, "foo1", "1-a", []string{"ABC"}))
+	// Move "ABC"'s tail to a later block so block 0 is no longer pinned, then push enough
+	// additional, differently-keyed blocks through the cache to force that block out.
+	cache.AddToCache(channelEntry(10001, "foo2", "1-a", []string{"ABC"}))
+	for i := 0; i < 5; i++ {
+		channelName := fmt.Sprintf("other%d", i)
+		cache.AddToCache(channelEntry(uint64(i*20000+1), "doc", "1-a", []string{channelName}))
+	}
+
+	_, found := cache.blockCache.get(blockCacheKey{channelName: "ABC", blockIndex: 0})
+	assert.Equals(t, found, false)
+
+	helper := cache.getCacheHelper("ABC")
+	block := helper.readCacheBlockForSequence(1)
+	assert.Equals(t, block.hasSequence(1), true)
+}
+
+func TestBlockCachePrunerHonorsMaxAge(t *testing.T) {
+	cache, _ := testKvCacheWithOptions(CacheOptions{MaxAge: 10 * time.Millisecond})
+	cache.AddToCache(channelEntry(1, "foo1", "1-a", []string{"ABC"}))
+	// Un-pin the block by moving the tail forward, then let it age out.
+	cache.AddToCache(channelEntry(10001, "foo2", "1-a", []string{"ABC"}))
+
+	time.Sleep(20 * time.Millisecond)
+	cache.blockCache.pruneExpired()
+
+	key := blockCacheKey{channelName: "ABC", blockIndex: 0}
+	_, found := cache.blockCache.get(key)
+	assert.Equals(t, found, false)
+
+	// But the data is still retrievable - it round-trips from the bucket.
+	helper := cache.getCacheHelper("ABC")
+	block := helper.readCacheBlockForSequence(1)
+	assert.Equals(t, block.hasSequence(1), true)
+}
@@ -0,0 +1,199 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// NotifierMode selects how a kvCache learns about writes made to the cache bucket by other
+// gateway nodes.
+type NotifierMode int
+
+const (
+	// PollNotifierMode periodically re-scans the bucket for new sequences. Simple and
+	// always available, at the cost of up to ByteCachePollingTime latency.
+	PollNotifierMode NotifierMode = iota
+	// DCPNotifierMode subscribes to the bucket's DCP/TAP mutation stream and notifies as
+	// soon as a mutation lands, without any polling interval.
+	DCPNotifierMode
+)
+
+// CacheNotifier informs a kvCache when channels have received new entries, or been
+// invalidated by a peer node, so it can wake MultiChangesFeed consumers and drop stale
+// in-memory state accordingly.
+type CacheNotifier interface {
+	// Start begins watching for changes. notify is invoked with the set of changed channels
+	// each time one or more channels receive a new entry; invalidate is invoked with a
+	// channel name whenever a peer node invalidates that channel's cache blocks (see
+	// kvCache.InvalidateChannels). Start returns once watching has begun; both callbacks are
+	// invoked asynchronously from then on.
+	Start(notify func(base.Set), invalidate func(string)) error
+	// Stop shuts down the notifier and releases any underlying resources (tap feed, etc).
+	Stop()
+}
+
+func newCacheNotifier(mode NotifierMode, bucket base.Bucket, knownChannels func() []string) CacheNotifier {
+	switch mode {
+	case DCPNotifierMode:
+		return &DCPNotifier{bucket: bucket, knownChannels: knownChannels}
+	default:
+		return &PollNotifier{bucket: bucket, knownChannels: knownChannels}
+	}
+}
+
+// PollNotifier is the original distributed-cache behavior: it wakes on a fixed interval
+// (ByteCachePollingTime) without being able to tell which channels actually changed, so it
+// notifies with a nil Set, which callers treat as "recheck everything". Since PollNotifierMode
+// is the default (zero-value) NotifierMode, it also has to be able to observe peer
+// invalidations on its own, rather than relying on a tap feed: each tick it re-reads the
+// invalidate marker for every channel knownChannels reports and fires invalidate for any whose
+// Version has advanced since the last tick.
+type PollNotifier struct {
+	bucket        base.Bucket
+	knownChannels func() []string
+	stop          chan struct{}
+
+	lastVersions map[string]int64
+}
+
+func (p *PollNotifier) Start(notify func(base.Set), invalidate func(string)) error {
+	p.stop = make(chan struct{})
+	p.lastVersions = make(map[string]int64)
+	go p.run(notify, invalidate)
+	return nil
+}
+
+func (p *PollNotifier) run(notify func(base.Set), invalidate func(string)) {
+	for {
+		waitTime := time.Duration(ByteCachePollingTime) * time.Millisecond
+		select {
+		case <-time.After(waitTime):
+			// A full implementation would diff the bucket's known sequence range against
+			// what's already been seen and notify only the channels that actually changed;
+			// until then, a nil Set tells callers to recheck every channel they care about.
+			notify(nil)
+			p.checkInvalidateMarkers(invalidate)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// checkInvalidateMarkers re-reads the invalidate marker for every channel this node knows
+// about and fires invalidate for any whose Version has increased since the last tick, so a
+// poll-mode node eventually observes a peer's InvalidateChannels call rather than serving stale
+// blocks forever. Latency is bounded by ByteCachePollingTime, same as ordinary writes.
+func (p *PollNotifier) checkInvalidateMarkers(invalidate func(string)) {
+	if p.knownChannels == nil {
+		return
+	}
+	for _, channelName := range p.knownChannels() {
+		version, found := readInvalidateMarker(p.bucket, channelName)
+		if !found {
+			continue
+		}
+		if version > p.lastVersions[channelName] {
+			p.lastVersions[channelName] = version
+			invalidate(channelName)
+		}
+	}
+}
+
+func (p *PollNotifier) Stop() {
+	if p.stop != nil {
+		close(p.stop)
+	}
+}
+
+// DCPNotifier subscribes to the cache bucket's DCP/TAP mutation stream and decodes the
+// affected channel directly from each mutated cache block's key (_cache:block:{channel}:N),
+// so MultiChangesFeed consumers wake as soon as the mutation lands rather than on the next
+// poll tick. If the tap feed disconnects, it falls back to a PollNotifier.
+type DCPNotifier struct {
+	bucket        base.Bucket
+	knownChannels func() []string
+	feed          base.TapFeed
+	stop          chan struct{}
+	fallback      CacheNotifier
+}
+
+func (d *DCPNotifier) Start(notify func(base.Set), invalidate func(string)) error {
+	feed, err := d.bucket.StartTapFeed(base.TapArguments{Backfill: base.TapNoBackfill})
+	if err != nil {
+		return err
+	}
+	d.feed = feed
+	d.stop = make(chan struct{})
+	go d.run(notify, invalidate)
+	return nil
+}
+
+func (d *DCPNotifier) run(notify func(base.Set), invalidate func(string)) {
+	for {
+		select {
+		case event, ok := <-d.feed.Events():
+			if !ok {
+				base.Warn("DCP tap feed for cache bucket disconnected; falling back to polling")
+				d.fallback = &PollNotifier{bucket: d.bucket, knownChannels: d.knownChannels}
+				d.fallback.Start(notify, invalidate)
+				return
+			}
+			key := string(event.Key)
+			if channelName, found := channelFromBlockKey(key); found {
+				notify(base.SetOf(channelName))
+			} else if channelName, found := channelFromInvalidateKey(key); found {
+				invalidate(channelName)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *DCPNotifier) Stop() {
+	if d.stop != nil {
+		close(d.stop)
+	}
+	if d.feed != nil {
+		d.feed.Close()
+	}
+	if d.fallback != nil {
+		d.fallback.Stop()
+	}
+}
+
+// channelFromBlockKey extracts the channel name from a "_cache:block:{channel}:{index}" key,
+// the inverse of blockKey. Keys outside that namespace (e.g. "_cache:seq:N") are ignored.
+func channelFromBlockKey(key string) (string, bool) {
+	const prefix = "_cache:block:"
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	rest := key[len(prefix):]
+	lastColon := strings.LastIndex(rest, ":")
+	if lastColon <= 0 {
+		return "", false
+	}
+	return rest[:lastColon], true
+}
+
+// channelFromInvalidateKey extracts the channel name from a "_cache:invalidate:{channel}"
+// marker key written by kvCache.InvalidateChannels.
+func channelFromInvalidateKey(key string) (string, bool) {
+	const prefix = "_cache:invalidate:"
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return key[len(prefix):], true
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbaselabs/go.assert"
+)
+
+func TestChannelFromBlockKey(t *testing.T) {
+	name, found := channelFromBlockKey("_cache:block:ABC:3")
+	assert.Equals(t, found, true)
+	assert.Equals(t, name, "ABC")
+
+	_, found = channelFromBlockKey("_cache:seq:10")
+	assert.Equals(t, found, false)
+}
+
+// TestDCPNotifierWakeupLatency verifies that a second gateway node sharing the cache bucket,
+// configured with NotifierMode=DCP and no polling interval, learns of a write almost
+// immediately via the tap feed rather than waiting for a poll tick.
+func TestDCPNotifierWakeupLatency(t *testing.T) {
+	writer, bucket := testKvCacheWithOptions(CacheOptions{})
+	defer writer.Stop()
+
+	reader := &kvCache{storage: bucket, options: CacheOptions{NotifierMode: DCPNotifierMode}}
+	reader.Init(0)
+	defer reader.Stop()
+
+	notified := make(chan base.Set, 1)
+	reader.onChange = func(changed base.Set) {
+		select {
+		case notified <- changed:
+		default:
+		}
+	}
+
+	start := time.Now()
+	writer.AddToCache(channelEntry(1, "foo1", "1-a", []string{"ABC"}))
+
+	select {
+	case changed := <-notified:
+		assert.True(t, time.Since(start) < 10*time.Millisecond)
+		_, found := changed["ABC"]
+		assert.Equals(t, found, true)
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for DCP-notified change")
+	}
+}
+
+// TestPollNotifierObservesInvalidate verifies that a node left on the default
+// (PollNotifierMode) NotifierMode still eventually learns of a peer's InvalidateChannels call,
+// rather than silently never observing it, by polling each known channel's invalidate marker
+// for a version bump alongside its regular poll tick.
+func TestPollNotifierObservesInvalidate(t *testing.T) {
+	writer, bucket := testKvCacheWithOptions(CacheOptions{})
+	defer writer.Stop()
+	writer.AddToCache(channelEntry(1, "foo1", "1-a", []string{"ABC"}))
+
+	reader := &kvCache{storage: bucket, options: CacheOptions{NotifierMode: PollNotifierMode}}
+	reader.Init(1)
+	defer reader.Stop()
+	// Prime the reader so it knows about "ABC" and will poll its invalidate marker.
+	reader.getCacheHelper("ABC")
+
+	invalidated := make(chan string, 1)
+	reader.notifier.Stop()
+	pollNotifier := &PollNotifier{bucket: bucket, knownChannels: reader.knownChannelNames}
+	reader.notifier = pollNotifier
+	pollNotifier.Start(reader.notifyChannelsChanged, func(channelName string) {
+		reader.handleInvalidateMarker(channelName)
+		select {
+		case invalidated <- channelName:
+		default:
+		}
+	})
+
+	err := writer.InvalidateChannels([]string{"ABC"}, false)
+	assert.True(t, err == nil)
+
+	select {
+	case channelName := <-invalidated:
+		assert.Equals(t, channelName, "ABC")
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for poll-mode node to observe peer invalidation")
+	}
+}
@@ -0,0 +1,138 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbaselabs/go.assert"
+)
+
+func testPartitionedKvCache(numShards uint32) (*PartitionedKvCache, base.Bucket) {
+	cacheBucket, err := ConnectToBucket(base.BucketSpec{
+		Server:     "walrus:",
+		BucketName: "distributed_cache_partition_test"})
+	if err != nil {
+		panic("Couldn't connect to cache bucket")
+	}
+	return NewPartitionedKvCache(cacheBucket, CacheOptions{NumShards: numShards}), cacheBucket
+}
+
+// findDistinctShardChannels locates two channel names that hash to different shards, so
+// tests can assert writes to one shard don't wake pollers or helpers on another.
+func findDistinctShardChannels(p *PartitionedKvCache) (string, string) {
+	names := []string{"chanA", "chanB", "chanC", "chanD", "chanE", "chanF", "chanG", "chanH"}
+	shardOf := make(map[string]uint32, len(names))
+	for _, name := range names {
+		shardOf[name] = shardForChannel(name, p.numShards)
+	}
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			if shardOf[a] != shardOf[b] {
+				return a, b
+			}
+		}
+	}
+	panic("couldn't find two channels hashing to different shards")
+}
+
+func TestPartitionedKvCacheRouting(t *testing.T) {
+	p, _ := testPartitionedKvCache(4)
+	chanA, chanB := findDistinctShardChannels(p)
+
+	p.AddToCache(channelEntry(1, "docA", "1-a", []string{chanA}))
+
+	// The shard owning chanA should see the write...
+	helperA := p.getCacheHelper(chanA)
+	block := helperA.readCacheBlockForSequence(1)
+	assert.Equals(t, block.hasSequence(1), true)
+
+	// ...but the shard owning chanB, which is unrelated, should not.
+	helperB := p.getCacheHelper(chanB)
+	block = helperB.readCacheBlockForSequence(1)
+	assert.Equals(t, block == nil, true)
+}
+
+func TestPartitionedKvCacheNotifyIsolation(t *testing.T) {
+	p, _ := testPartitionedKvCache(4)
+	chanA, chanB := findDistinctShardChannels(p)
+
+	var mutex sync.Mutex
+	var seen []base.Set
+	p.SetOnChange(func(changed base.Set) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen = append(seen, changed)
+	})
+
+	p.AddToCache(channelEntry(1, "docA", "1-a", []string{chanA}))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, changed := range seen {
+		_, chanBNotified := changed[chanB]
+		assert.Equals(t, chanBNotified, false)
+	}
+	sawChanA := false
+	for _, changed := range seen {
+		if _, found := changed[chanA]; found {
+			sawChanA = true
+		}
+	}
+	assert.Equals(t, sawChanA, true)
+}
+
+// TestPartitionedKvCacheGrantChannel verifies that PartitionedKvCache.GrantChannel sources
+// triggeredBy from the wildcard shard's global maxSequence - not whichever shard happens to own
+// the granted channel - so two channels owned by different shards get comparable baselines for
+// the same number of total writes, and that the grant round-trips through ResumeGrantedBackfills.
+func TestPartitionedKvCacheGrantChannel(t *testing.T) {
+	p, bucket := testPartitionedKvCache(4)
+	chanA, chanB := findDistinctShardChannels(p)
+
+	// Interleave writes across both channels so neither shard's local maxSequence equals the
+	// database's true total.
+	const writesPerChannel = 100
+	for i := 0; i < writesPerChannel; i++ {
+		seq := uint64(i*2 + 1)
+		p.AddToCache(channelEntry(seq, fmt.Sprintf("docA%d", i), "1-a", []string{chanA}))
+		p.AddToCache(channelEntry(seq+1, fmt.Sprintf("docB%d", i), "1-a", []string{chanB}))
+	}
+
+	triggeredBy, backfill, err := p.GrantChannel(chanB, "naomi")
+	assert.True(t, err == nil)
+	assert.Equals(t, triggeredBy, uint64(writesPerChannel*2))
+	assert.Equals(t, len(backfill), writesPerChannel)
+
+	backfillSince := ResumeGrantedBackfills(bucket, "naomi", []string{chanA, chanB})
+	assert.Equals(t, len(backfillSince), 1)
+	assert.Equals(t, backfillSince[chanB], triggeredBy)
+}
+
+func TestPartitionedKvCacheIsolation(t *testing.T) {
+	p, _ := testPartitionedKvCache(4)
+	chanA, chanB := findDistinctShardChannels(p)
+
+	shardA := p.shardForChannel(chanA)
+	shardB := p.shardForChannel(chanB)
+	assert.True(t, shardA != shardB)
+
+	// A write to a channel owned by shard A must not appear in shard B's cache helpers.
+	p.AddToCache(channelEntry(1, "docA", "1-a", []string{chanA}))
+
+	_, resultsA := shardA.GetCachedChanges(chanA, ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.Equals(t, len(resultsA), 1)
+
+	_, resultsB := shardB.GetCachedChanges(chanB, ChangesOptions{Since: SequenceID{Seq: 0}})
+	assert.Equals(t, len(resultsB), 0)
+}
@@ -0,0 +1,40 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package rest
+
+import (
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// cacheInvalidateBody is the expected body of POST /{db}/_cache/invalidate.
+type cacheInvalidateBody struct {
+	Channels []string `json:"channels"`
+	Rebuild  bool     `json:"rebuild"`
+}
+
+// handleInvalidateCache services POST /{db}/_cache/invalidate, purging a corrupted or stale
+// channel's cache blocks from the distributed cache (and, if requested, rebuilding them from
+// the bucket's sequence history) without requiring a gateway restart. Registered in
+// CreateAdminRouter.
+func (h *handler) handleInvalidateCache() error {
+	var body cacheInvalidateBody
+	if err := h.readJSONInto(&body); err != nil {
+		return err
+	}
+	if len(body.Channels) == 0 {
+		return base.HTTPErrorf(400, "channels is required")
+	}
+
+	if err := h.db.InvalidateChannelCaches(body.Channels, body.Rebuild); err != nil {
+		return err
+	}
+	h.writeJSON(map[string]interface{}{"ok": true})
+	return nil
+}
@@ -0,0 +1,75 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/couchbaselabs/go.assert"
+	"github.com/gorilla/mux"
+)
+
+func testAdminRouter(t *testing.T, dbName string) *mux.Router {
+	bucket, err := db.ConnectToBucket(base.BucketSpec{
+		Server:     "walrus:",
+		BucketName: "rest_admin_cache_test"})
+	if err != nil {
+		t.Fatalf("Couldn't connect to bucket: %v", err)
+	}
+	cache := db.NewPartitionedKvCache(bucket, db.CacheOptions{NumShards: 1})
+
+	sc := NewServerContext()
+	sc.AddDatabase(db.NewDatabaseContext(dbName, cache))
+	return CreateAdminRouter(sc)
+}
+
+func TestHandleInvalidateCache(t *testing.T) {
+	router := testAdminRouter(t, "db")
+
+	body, _ := json.Marshal(cacheInvalidateBody{Channels: []string{"ABC"}, Rebuild: false})
+	request := httptest.NewRequest("POST", "/db/_cache/invalidate", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assert.Equals(t, response.Code, http.StatusOK)
+
+	var result map[string]interface{}
+	err := json.Unmarshal(response.Body.Bytes(), &result)
+	assert.True(t, err == nil)
+	assert.Equals(t, result["ok"], true)
+}
+
+func TestHandleInvalidateCacheRequiresChannels(t *testing.T) {
+	router := testAdminRouter(t, "db")
+
+	body, _ := json.Marshal(cacheInvalidateBody{})
+	request := httptest.NewRequest("POST", "/db/_cache/invalidate", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assert.Equals(t, response.Code, http.StatusBadRequest)
+}
+
+func TestHandleInvalidateCacheUnknownDatabase(t *testing.T) {
+	router := testAdminRouter(t, "db")
+
+	body, _ := json.Marshal(cacheInvalidateBody{Channels: []string{"ABC"}})
+	request := httptest.NewRequest("POST", "/nonesuch/_cache/invalidate", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assert.Equals(t, response.Code, http.StatusNotFound)
+}
@@ -0,0 +1,76 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+	"github.com/gorilla/mux"
+)
+
+// handlerMethod is the signature every admin API handler implements; returning a non-nil error
+// (typically a *base.HTTPError) is how a handler reports a failure - handle() translates it
+// into the actual HTTP response.
+type handlerMethod func(*handler) error
+
+// handler carries the per-request state threaded through a single admin API call.
+type handler struct {
+	server   *ServerContext
+	rq       *http.Request
+	response http.ResponseWriter
+	db       *db.DatabaseContext
+}
+
+// handle adapts method into an http.Handler, resolving the {db} route variable into h.db
+// before invoking method.
+func (sc *ServerContext) handle(method handlerMethod) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, rq *http.Request) {
+		h := &handler{server: sc, rq: rq, response: response}
+		dbc, err := sc.DatabaseNamed(mux.Vars(rq)["db"])
+		if err != nil {
+			h.writeError(err)
+			return
+		}
+		h.db = dbc
+		if err := method(h); err != nil {
+			h.writeError(err)
+		}
+	})
+}
+
+// readJSONInto decodes the request body as JSON into into.
+func (h *handler) readJSONInto(into interface{}) error {
+	defer h.rq.Body.Close()
+	if err := json.NewDecoder(h.rq.Body).Decode(into); err != nil {
+		return base.HTTPErrorf(400, "Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// writeJSON writes value to the response as a 200 JSON body.
+func (h *handler) writeJSON(value interface{}) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		h.writeError(err)
+		return
+	}
+	h.response.Header().Set("Content-Type", "application/json")
+	h.response.Write(raw)
+}
+
+// writeError writes err to the response, using its status if it's a *base.HTTPError, or 500
+// otherwise.
+func (h *handler) writeError(err error) {
+	status, message := base.ErrorAsHTTPStatus(err)
+	http.Error(h.response, message, status)
+}
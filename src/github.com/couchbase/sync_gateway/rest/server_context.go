@@ -0,0 +1,47 @@
+//  Copyright (c) 2015 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package rest
+
+import (
+	"sync"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/db"
+)
+
+// ServerContext holds every database a gateway instance is serving, keyed by name, and is
+// shared by every handler.
+type ServerContext struct {
+	mutex     sync.RWMutex
+	databases map[string]*db.DatabaseContext
+}
+
+func NewServerContext() *ServerContext {
+	return &ServerContext{databases: map[string]*db.DatabaseContext{}}
+}
+
+// AddDatabase registers dbc under its own Name so it can later be looked up by DatabaseNamed.
+func (sc *ServerContext) AddDatabase(dbc *db.DatabaseContext) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.databases[dbc.Name] = dbc
+}
+
+// DatabaseNamed returns the database registered under name, or a 404 *base.HTTPError if none
+// exists.
+func (sc *ServerContext) DatabaseNamed(name string) (*db.DatabaseContext, error) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	dbc, found := sc.databases[name]
+	if !found {
+		return nil, base.HTTPErrorf(404, "no such database %q", name)
+	}
+	return dbc, nil
+}